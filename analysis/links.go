@@ -6,29 +6,63 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"regexp"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
+
+	"git.autistici.org/ale/crawl"
 )
 
 var (
-	urlcssRx = regexp.MustCompile(`background.*:.*url\(["']?([^'"\)]+)["']?\)`)
+	// urlcssRx matches any url(...) occurrence in CSS, covering
+	// background/background-image, @import and src (as used in
+	// @font-face), not just "background".
+	urlcssRx = regexp.MustCompile(`url\(["']?([^'"\)]+)["']?\)`)
+
+	metaRefreshRx = regexp.MustCompile(`(?i)url\s*=\s*(.+)$`)
 
+	// linkMatches lists the tag/attribute pairs that carry a single
+	// URL, along with the tag used for the resulting Outlink.
 	linkMatches = []struct {
 		tag  string
 		attr string
+		otag int
+	}{
+		{"a", "href", crawl.TagPrimary},
+		{"area", "href", crawl.TagPrimary},
+		{"form", "action", crawl.TagPrimary},
+		{"link", "href", crawl.TagRelated},
+		{"img", "src", crawl.TagRelated},
+		{"script", "src", crawl.TagRelated},
+		{"iframe", "src", crawl.TagRelated},
+		{"source", "src", crawl.TagRelated},
+		{"video", "src", crawl.TagRelated},
+		{"video", "poster", crawl.TagRelated},
+		{"audio", "src", crawl.TagRelated},
+		{"object", "data", crawl.TagRelated},
+		{"embed", "src", crawl.TagRelated},
+		{"track", "src", crawl.TagRelated},
+	}
+
+	// srcsetMatches lists the tag/attribute pairs whose value is a
+	// srcset, i.e. a comma-separated list of URL candidates.
+	srcsetMatches = []struct {
+		tag  string
+		attr string
 	}{
-		{"a", "href"},
-		{"link", "href"},
-		{"img", "src"},
-		{"script", "src"},
+		{"img", "srcset"},
+		{"source", "srcset"},
 	}
 )
 
-func GetLinks(resp *http.Response) ([]*url.URL, error) {
-	var outlinks []string
+type taggedURL struct {
+	url string
+	tag int
+}
+
+func GetLinks(resp *http.Response) ([]crawl.Outlink, error) {
+	var outlinks []taggedURL
 
 	ctype := resp.Header.Get("Content-Type")
 	if strings.HasPrefix(ctype, "text/html") {
@@ -38,30 +72,107 @@ func GetLinks(resp *http.Response) ([]*url.URL, error) {
 		}
 
 		for _, lm := range linkMatches {
+			lm := lm
 			doc.Find(fmt.Sprintf("%s[%s]", lm.tag, lm.attr)).Each(func(i int, s *goquery.Selection) {
 				val, _ := s.Attr(lm.attr)
-				outlinks = append(outlinks, val)
+				outlinks = append(outlinks, taggedURL{val, lm.otag})
+			})
+		}
+
+		for _, sm := range srcsetMatches {
+			sm := sm
+			doc.Find(fmt.Sprintf("%s[%s]", sm.tag, sm.attr)).Each(func(i int, s *goquery.Selection) {
+				val, _ := s.Attr(sm.attr)
+				for _, u := range parseSrcset(val) {
+					outlinks = append(outlinks, taggedURL{u, crawl.TagRelated})
+				}
 			})
 		}
+
+		doc.Find(`link[rel="canonical"][href]`).Each(func(i int, s *goquery.Selection) {
+			val, _ := s.Attr("href")
+			outlinks = append(outlinks, taggedURL{val, crawl.TagPrimary})
+		})
+
+		// Match http-equiv case-insensitively in Go rather than
+		// relying on the CSS4 "i" selector flag, which the
+		// vendored cascadia selector parser may not support.
+		doc.Find(`meta[http-equiv][content]`).Each(func(i int, s *goquery.Selection) {
+			httpEquiv, _ := s.Attr("http-equiv")
+			if !strings.EqualFold(httpEquiv, "refresh") {
+				return
+			}
+			content, _ := s.Attr("content")
+			if m := metaRefreshRx.FindStringSubmatch(content); m != nil {
+				outlinks = append(outlinks, taggedURL{strings.Trim(m[1], `"'`), crawl.TagPrimary})
+			}
+		})
+
+		doc.Find("style").Each(func(i int, s *goquery.Selection) {
+			for _, u := range findCSSURLs(s.Text()) {
+				outlinks = append(outlinks, taggedURL{u, crawl.TagRelated})
+			}
+		})
+
+		doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
+			val, _ := s.Attr("style")
+			for _, u := range findCSSURLs(val) {
+				outlinks = append(outlinks, taggedURL{u, crawl.TagRelated})
+			}
+		})
 	} else if strings.HasPrefix(ctype, "text/css") {
 		if data, err := ioutil.ReadAll(resp.Body); err == nil {
-			for _, val := range urlcssRx.FindAllStringSubmatch(string(data), -1) {
-				outlinks = append(outlinks, val[1])
+			for _, u := range findCSSURLs(string(data)) {
+				outlinks = append(outlinks, taggedURL{u, crawl.TagRelated})
 			}
 		}
 	}
 
-	// Uniquify and parse outbound links.
-	var result []*url.URL
-	links := make(map[string]*url.URL)
+	// Uniquify and parse outbound links, keeping the most specific
+	// tag (TagPrimary) if the same URL was seen with different tags.
+	result := make(map[string]crawl.Outlink)
 	for _, val := range outlinks {
-		if linkurl, err := resp.Request.URL.Parse(val); err == nil {
-			links[linkurl.String()] = linkurl
+		linkurl, err := resp.Request.URL.Parse(val.url)
+		if err != nil {
+			continue
+		}
+		key := linkurl.String()
+		if existing, ok := result[key]; ok {
+			if existing.Tag == crawl.TagPrimary || val.tag == crawl.TagRelated {
+				continue
+			}
 		}
+		result[key] = crawl.Outlink{URL: linkurl, Tag: val.tag}
 	}
-	for _, link := range links {
-		result = append(result, link)
+
+	var links []crawl.Outlink
+	for _, link := range result {
+		links = append(links, link)
 	}
 
-	return result, nil
+	return links, nil
+}
+
+// findCSSURLs returns every url(...) occurrence in a chunk of CSS
+// text, including @import and src: declarations.
+func findCSSURLs(css string) []string {
+	var urls []string
+	for _, m := range urlcssRx.FindAllStringSubmatch(css, -1) {
+		urls = append(urls, m[1])
+	}
+	return urls
+}
+
+// parseSrcset splits a srcset attribute value into its candidate
+// URLs, discarding the descriptor (width/pixel density) that follows
+// each one.
+func parseSrcset(srcset string) []string {
+	var urls []string
+	for _, candidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(strings.TrimSpace(candidate))
+		if len(fields) > 0 {
+			urls = append(urls, fields[0])
+		}
+	}
+	return urls
 }
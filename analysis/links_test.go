@@ -0,0 +1,173 @@
+package analysis
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"git.autistici.org/ale/crawl"
+)
+
+func getLinksFromHTML(t *testing.T, html string) map[string]int {
+	t.Helper()
+	reqURL, err := url.Parse("https://example.com/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		Body:       ioutil.NopCloser(strings.NewReader(html)),
+		Request:    &http.Request{URL: reqURL},
+	}
+	links, err := GetLinks(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]int)
+	for _, l := range links {
+		got[l.URL.String()] = l.Tag
+	}
+	return got
+}
+
+func TestGetLinksNewElements(t *testing.T) {
+	html := `<html><body>
+<iframe src="/iframe"></iframe>
+<source src="/source"></source>
+<source srcset="/src1 1x, /src2 2x">
+<img srcset="/img1 480w, /img2 800w">
+<video src="/video" poster="/poster"></video>
+<audio src="/audio"></audio>
+<object data="/object"></object>
+<embed src="/embed">
+<form action="/form"></form>
+<area href="/area">
+<track src="/track">
+<link rel="stylesheet" href="/style.css">
+<link rel="canonical" href="/canonical">
+<meta http-equiv="Refresh" content="5; url=/refresh">
+</body></html>`
+
+	got := getLinksFromHTML(t, html)
+
+	wantPrimary := []string{"/form", "/area", "/canonical", "/refresh"}
+	wantRelated := []string{
+		"/iframe", "/source", "/src1", "/src2", "/img1", "/img2",
+		"/video", "/poster", "/audio", "/object", "/embed", "/track",
+		"/style.css",
+	}
+	for _, p := range wantPrimary {
+		u := "https://example.com" + p
+		tag, ok := got[u]
+		if !ok {
+			t.Errorf("missing expected link %s", u)
+			continue
+		}
+		if tag != crawl.TagPrimary {
+			t.Errorf("%s: got tag %d, want TagPrimary", u, tag)
+		}
+	}
+	for _, p := range wantRelated {
+		u := "https://example.com" + p
+		tag, ok := got[u]
+		if !ok {
+			t.Errorf("missing expected link %s", u)
+			continue
+		}
+		if tag != crawl.TagRelated {
+			t.Errorf("%s: got tag %d, want TagRelated", u, tag)
+		}
+	}
+}
+
+func TestGetLinksInlineCSS(t *testing.T) {
+	html := `<html><head>
+<style>
+body { background: url(/bg.png); }
+@import url("/imported.css");
+@font-face { src: url('/font.woff'); }
+</style>
+</head>
+<body style="background-image: url(/inline-bg.png)"></body>
+</html>`
+
+	got := getLinksFromHTML(t, html)
+	want := []string{"/bg.png", "/imported.css", "/font.woff", "/inline-bg.png"}
+	for _, p := range want {
+		u := "https://example.com" + p
+		if tag, ok := got[u]; !ok {
+			t.Errorf("missing expected CSS link %s", u)
+		} else if tag != crawl.TagRelated {
+			t.Errorf("%s: got tag %d, want TagRelated", u, tag)
+		}
+	}
+}
+
+func TestGetLinksTagUpgradeOnConflict(t *testing.T) {
+	// The same URL appears both as a TagRelated <img> and a
+	// TagPrimary <a>; the merge should keep TagPrimary regardless
+	// of which one is encountered first.
+	html := `<html><body>
+<a href="/shared"></a>
+<img src="/shared">
+</body></html>`
+
+	got := getLinksFromHTML(t, html)
+	tag, ok := got["https://example.com/shared"]
+	if !ok {
+		t.Fatal("missing expected link /shared")
+	}
+	if tag != crawl.TagPrimary {
+		t.Errorf("got tag %d, want TagPrimary to win over TagRelated", tag)
+	}
+}
+
+func TestParseSrcset(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"/a.jpg 1x, /b.jpg 2x", []string{"/a.jpg", "/b.jpg"}},
+		{"/a.jpg", []string{"/a.jpg"}},
+		{" /a.jpg 480w , /b.jpg 800w ", []string{"/a.jpg", "/b.jpg"}},
+		{"", nil},
+	}
+	for _, c := range cases {
+		got := parseSrcset(c.in)
+		if len(got) != len(c.want) {
+			t.Errorf("parseSrcset(%q) = %v, want %v", c.in, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("parseSrcset(%q) = %v, want %v", c.in, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestFindCSSURLs(t *testing.T) {
+	css := `
+.a { background: url(/a.png); }
+@import url("/b.css");
+@font-face { src: url('/c.woff'); }
+`
+	got := findCSSURLs(css)
+	want := []string{"/a.png", "/b.css", "/c.woff"}
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Fatalf("findCSSURLs() = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("findCSSURLs() = %v, want %v", got, want)
+			break
+		}
+	}
+}
@@ -4,6 +4,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
 	"flag"
 	"fmt"
 	"io"
@@ -11,17 +13,33 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
-	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"git.autistici.org/ale/crawl"
 	"git.autistici.org/ale/crawl/analysis"
 	"git.autistici.org/ale/crawl/warc"
 )
 
+// digestRecord remembers the WARC record that first carried a given
+// response payload, so that later occurrences can be replaced with a
+// "revisit" record instead.
+type digestRecord struct {
+	RecordID  string
+	Date      string
+	TargetURI string
+}
+
+// payloadDigest computes the WARC-Payload-Digest value for data,
+// using the sha1:<base32> encoding mandated by the WARC 1.0 spec.
+func payloadDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
 var (
 	dbPath       = flag.String("state", "crawldb", "crawl state database path")
 	keepDb       = flag.Bool("keep", false, "keep the state database when done")
@@ -29,6 +47,9 @@ var (
 	depth        = flag.Int("depth", 10, "maximum link depth")
 	validSchemes = flag.String("schemes", "http,https", "comma-separated list of allowed protocols")
 	outputFile   = flag.String("output", "crawl.warc.gz", "output WARC file")
+	recrawl      = flag.Duration("recrawl", 0, "if non-zero, re-crawl URLs older than this interval instead of skipping them. Note that a 304 response is recorded as a revisit without re-extracting outlinks, so pages that stay 304 indefinitely will not have their links re-walked; only the original seeds are re-enqueued on every run")
+	useSitemaps  = flag.Bool("sitemaps", true, "seed the crawl from each seed host's sitemap.xml, as well as any sitemaps advertised via robots.txt")
+	metricsAddr  = flag.String("metrics-addr", "", "if set, serve Prometheus metrics on this address (e.g. :9180)")
 )
 
 func extractLinks(c *crawl.Crawler, u string, depth int, resp *http.Response, err error) error {
@@ -64,6 +85,10 @@ type warcSaveHandler struct {
 }
 
 func (h *warcSaveHandler) Handle(c *crawl.Crawler, u string, depth int, resp *http.Response, err error) error {
+	if err == nil && resp.StatusCode == http.StatusNotModified {
+		return h.handleNotModified(c, u, resp)
+	}
+
 	data, derr := ioutil.ReadAll(resp.Body)
 	if derr != nil {
 		return err
@@ -82,23 +107,94 @@ func (h *warcSaveHandler) Handle(c *crawl.Crawler, u string, depth int, resp *ht
 	w.Write(b.Bytes())
 	w.Close()
 
-	// Dump the response.
-	statusLine := fmt.Sprintf("HTTP/1.1 %s", resp.Status)
-	respPayload := bytes.Join([][]byte{
-		[]byte(statusLine), hdr2str(resp.Header), data},
-		[]byte{'\r', '\n'})
+	// Dump the response, deduplicating identical payloads with a
+	// WARC revisit record per the "identical-payload-digest"
+	// profile (ISO 28500).
+	digest := payloadDigest(data)
+	digestKey := []byte(fmt.Sprintf("digest/%s", digest))
+
 	hdr = warc.NewHeader()
-	hdr.Set("WARC-Type", "response")
 	hdr.Set("WARC-Target-URI", resp.Request.URL.String())
 	hdr.Set("WARC-Warcinfo-ID", h.warcInfoID)
-	hdr.Set("Content-Length", strconv.Itoa(len(respPayload)))
-	w = h.warc.NewRecord(hdr)
-	w.Write(respPayload)
-	w.Close()
+	hdr.Set("WARC-Payload-Digest", digest)
+
+	statusLine := fmt.Sprintf("HTTP/1.1 %s", resp.Status)
+	respHeader := bytes.Join([][]byte{
+		[]byte(statusLine), hdr2str(resp.Header)},
+		[]byte{'\r', '\n'})
+
+	urlKey := []byte(fmt.Sprintf("urlrecord/%s", u))
+
+	var prior digestRecord
+	if c.GetObj(digestKey, &prior) == nil {
+		hdr.Set("WARC-Type", "revisit")
+		hdr.Set("WARC-Profile", "http://netpreserve.org/warc/1.0/revisit/identical-payload-digest")
+		hdr.Set("WARC-Refers-To", prior.RecordID)
+		hdr.Set("WARC-Refers-To-Target-URI", prior.TargetURI)
+		hdr.Set("WARC-Refers-To-Date", prior.Date)
+		hdr.Set("Content-Length", strconv.Itoa(len(respHeader)))
+		w = h.warc.NewRecord(hdr)
+		w.Write(respHeader)
+		w.Close()
+
+		c.PutObj(urlKey, &prior) // nolint
+	} else {
+		hdr.Set("WARC-Type", "response")
+		respPayload := bytes.Join([][]byte{respHeader, data}, []byte{'\r', '\n'})
+		hdr.Set("Content-Length", strconv.Itoa(len(respPayload)))
+		w = h.warc.NewRecord(hdr)
+		w.Write(respPayload)
+		w.Close()
+
+		rec := &digestRecord{
+			RecordID:  hdr.Get("WARC-Record-ID"),
+			Date:      hdr.Get("WARC-Date"),
+			TargetURI: resp.Request.URL.String(),
+		}
+		c.PutObj(digestKey, rec) // nolint
+		c.PutObj(urlKey, rec)    // nolint
+	}
 
 	return extractLinks(c, u, depth, resp, err)
 }
 
+// handleNotModified records a 304 response (returned by a
+// conditional GET) as a WARC revisit record using the
+// "server-not-modified" profile, without re-extracting links: the
+// page contents have not changed since the last crawl, so we trust
+// that its outlinks have not changed either. This means a page that
+// keeps returning 304 will never have its links re-walked on
+// subsequent -recrawl runs; only the original seeds get re-enqueued
+// every time, so deep pages rely on their own URLInfo aging past
+// -recrawl independently of their parent page's status.
+func (h *warcSaveHandler) handleNotModified(c *crawl.Crawler, u string, resp *http.Response) error {
+	hdr := warc.NewHeader()
+	hdr.Set("WARC-Type", "revisit")
+	hdr.Set("WARC-Profile", "http://netpreserve.org/warc/1.0/revisit/server-not-modified")
+	hdr.Set("WARC-Target-URI", u)
+	hdr.Set("WARC-Warcinfo-ID", h.warcInfoID)
+
+	var prior digestRecord
+	urlKey := []byte(fmt.Sprintf("urlrecord/%s", u))
+	if c.GetObj(urlKey, &prior) == nil {
+		hdr.Set("WARC-Refers-To", prior.RecordID)
+		hdr.Set("WARC-Refers-To-Target-URI", prior.TargetURI)
+		hdr.Set("WARC-Refers-To-Date", prior.Date)
+	}
+
+	statusLine := fmt.Sprintf("HTTP/1.1 %s", resp.Status)
+	respHeader := bytes.Join([][]byte{
+		[]byte(statusLine), hdr2str(resp.Header)},
+		[]byte{'\r', '\n'})
+	hdr.Set("Content-Length", strconv.Itoa(len(respHeader)))
+
+	w := h.warc.NewRecord(hdr)
+	w.Write(respHeader)
+	w.Close()
+
+	return nil
+}
+
 func NewSaveHandler(w *warc.Writer) crawl.Handler {
 	info := strings.Join([]string{
 		"Software: crawl/1.0\r\n",
@@ -119,88 +215,78 @@ func NewSaveHandler(w *warc.Writer) crawl.Handler {
 	}
 }
 
-type crawlStats struct {
-	bytes int64
-	start time.Time
-
-	lock   sync.Mutex
-	states map[int]int
-}
-
-func (c *crawlStats) Update(resp *http.Response) {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-
-	c.states[resp.StatusCode]++
-	resp.Body = &byteCounter{resp.Body}
-}
-
-func (c *crawlStats) UpdateBytes(n int64) {
-	atomic.AddInt64(&c.bytes, n)
+// httpFetcher is a crawl.Fetcher (and crawl.ConditionalFetcher) that
+// retrieves URLs with a plain http.Client. Fetch-level instrumentation
+// (status codes, bytes, duration) is handled by the crawl package
+// itself via the metrics subpackage.
+type httpFetcher struct {
+	client *http.Client
 }
 
-func (c *crawlStats) Dump() {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	rate := float64(c.bytes) / time.Since(c.start).Seconds() / 1000
-	fmt.Fprintf(os.Stderr, "stats: downloaded %d bytes (%.4g KB/s), status: %v\n", c.bytes, rate, c.states)
+func (f *httpFetcher) Fetch(urlstr string) (*http.Response, error) {
+	return f.client.Get(urlstr)
 }
 
-var (
-	stats *crawlStats
-
-	client *http.Client
-)
-
-func fetch(urlstr string) (*http.Response, error) {
-	resp, err := client.Get(urlstr)
-	if err == nil {
-		stats.Update(resp)
+func (f *httpFetcher) FetchConditional(urlstr, etag, lastModified string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", urlstr, nil)
+	if err != nil {
+		return nil, err
 	}
-	return resp, err
-}
-
-func init() {
-	client = &http.Client{}
-
-	stats = &crawlStats{
-		states: make(map[int]int),
-		start:  time.Now(),
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
 	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return f.client.Do(req)
+}
 
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
 	go func() {
-		for range time.Tick(10 * time.Second) {
-			stats.Dump()
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("metrics server: %v", err)
 		}
 	}()
 }
 
-type byteCounter struct {
-	io.ReadCloser
-}
-
-func (b *byteCounter) Read(buf []byte) (int, error) {
-	n, err := b.ReadCloser.Read(buf)
-	if n > 0 {
-		stats.UpdateBytes(int64(n))
-	}
-	return n, err
-}
-
 func main() {
 	flag.Parse()
 
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
+
 	outf, err := os.Create(*outputFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	seeds := crawl.MustParseURLs(flag.Args())
+
+	fetcher := &httpFetcher{client: &http.Client{}}
+	polite := crawl.NewPoliteFetcher(fetcher,
+		crawl.WithRobotsCachePath(filepath.Join(*dbPath, "robots")))
+
 	scope := []crawl.Scope{
 		crawl.NewSchemeScope(strings.Split(*validSchemes, ",")),
 		crawl.NewDepthScope(*depth),
 		crawl.NewSeedScope(seeds),
 		crawl.NewRegexpIgnoreScope(nil),
+		crawl.NewRobotsScope(polite),
+	}
+
+	if *useSitemaps {
+		seeder := crawl.NewSitemapSeeder(polite, seeds)
+		for _, u := range seeds {
+			for _, sitemapURL := range polite.Sitemaps(u) {
+				seeder.AddSitemapURL(sitemapURL)
+			}
+		}
+		for _, outlink := range seeder.Seeds() {
+			seeds = append(seeds, outlink.URL)
+		}
 	}
 
 	w := warc.NewWriter(outf)
@@ -208,7 +294,21 @@ func main() {
 
 	saver := NewSaveHandler(w)
 
-	crawler, err := crawl.NewCrawler(*dbPath, seeds, scope, crawl.FetcherFunc(fetch), crawl.NewRedirectHandler(saver))
+	var opts []crawl.CrawlerOption
+	if *recrawl > 0 {
+		opts = append(opts, crawl.WithRecrawlInterval(*recrawl))
+
+		// -recrawl is only useful if the state database (which
+		// holds CrawledAt/ETag/Last-Modified, and the robots
+		// cache) survives between runs, so force -keep rather
+		// than silently degrading to a one-shot crawl.
+		if !*keepDb {
+			log.Printf("-recrawl requires -keep to persist crawl state between runs; enabling -keep")
+			*keepDb = true
+		}
+	}
+
+	crawler, err := crawl.NewCrawler(*dbPath, seeds, scope, polite, crawl.NewRedirectHandler(saver), opts...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"git.autistici.org/ale/crawl"
+	"git.autistici.org/ale/crawl/warc"
+)
+
+func newTestCrawler(t *testing.T) *crawl.Crawler {
+	t.Helper()
+	seeds := crawl.MustParseURLs([]string{"https://example.com/"})
+	fetcher := crawl.FetcherFunc(func(string) (*http.Response, error) {
+		return nil, nil
+	})
+	noopHandler := crawl.HandlerFunc(func(*crawl.Crawler, string, int, *http.Response, error) error {
+		return nil
+	})
+	c, err := crawl.NewCrawler(t.TempDir(), seeds, crawl.NewDepthScope(10), fetcher, noopHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func newTestResponse(t *testing.T, rawurl, body string) *http.Response {
+	t.Helper()
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    &http.Request{Method: "GET", URL: u, Header: http.Header{}},
+	}
+}
+
+func TestWarcSaveHandlerDedupesIdenticalPayloads(t *testing.T) {
+	c := newTestCrawler(t)
+
+	var buf bytes.Buffer
+	w := warc.NewWriter(&buf)
+	h := NewSaveHandler(w).(*warcSaveHandler)
+
+	if err := h.Handle(c, "https://example.com/a", 0, newTestResponse(t, "https://example.com/a", "same body"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Handle(c, "https://example.com/b", 0, newTestResponse(t, "https://example.com/b", "same body"), nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close() // nolint
+
+	out := buf.String()
+	if n := strings.Count(out, "WARC-Type: response"); n != 1 {
+		t.Errorf("expected exactly one response record, got %d\n%s", n, out)
+	}
+	if n := strings.Count(out, "WARC-Type: revisit"); n != 1 {
+		t.Errorf("expected exactly one revisit record for the duplicate payload, got %d\n%s", n, out)
+	}
+	if !strings.Contains(out, "http://netpreserve.org/warc/1.0/revisit/identical-payload-digest") {
+		t.Error("expected the revisit record to use the identical-payload-digest profile")
+	}
+}
+
+func TestWarcSaveHandlerDistinctPayloadsAreNotDeduped(t *testing.T) {
+	c := newTestCrawler(t)
+
+	var buf bytes.Buffer
+	w := warc.NewWriter(&buf)
+	h := NewSaveHandler(w).(*warcSaveHandler)
+
+	if err := h.Handle(c, "https://example.com/a", 0, newTestResponse(t, "https://example.com/a", "body one"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Handle(c, "https://example.com/b", 0, newTestResponse(t, "https://example.com/b", "body two"), nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close() // nolint
+
+	out := buf.String()
+	if n := strings.Count(out, "WARC-Type: response"); n != 2 {
+		t.Errorf("expected two distinct response records, got %d\n%s", n, out)
+	}
+	if strings.Contains(out, "WARC-Type: revisit") {
+		t.Error("did not expect a revisit record for distinct payloads")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
@@ -20,6 +21,8 @@ import (
 	"github.com/syndtr/goleveldb/leveldb"
 	lerr "github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+
+	"git.autistici.org/ale/crawl/metrics"
 )
 
 var (
@@ -88,10 +91,12 @@ const (
 
 // URLInfo stores information about a crawled URL.
 type URLInfo struct {
-	URL        string
-	StatusCode int
-	CrawledAt  time.Time
-	Error      string
+	URL          string
+	StatusCode   int
+	CrawledAt    time.Time
+	Error        string
+	ETag         string
+	LastModified string
 }
 
 // A Fetcher retrieves contents from remote URLs.
@@ -108,6 +113,18 @@ func (f FetcherFunc) Fetch(u string) (*http.Response, error) {
 	return f(u)
 }
 
+// A ConditionalFetcher is a Fetcher that can also perform a
+// conditional GET, using the ETag/Last-Modified validators stored
+// from a previous crawl of the same URL. Fetchers that implement
+// this interface are used automatically by the Crawler whenever
+// such validators are available.
+type ConditionalFetcher interface {
+	// FetchConditional retrieves a URL, sending If-None-Match
+	// and/or If-Modified-Since request headers built from the
+	// given validators (either of which may be empty).
+	FetchConditional(u, etag, lastModified string) (*http.Response, error)
+}
+
 // A Handler processes crawled contents. Any errors returned by public
 // implementations of this interface are considered fatal and will
 // cause the crawl to abort. The URL will be removed from the queue
@@ -138,12 +155,27 @@ type Crawler struct {
 	fetcher Fetcher
 	handler Handler
 
+	recrawlInterval time.Duration
+
 	workerCtx   context.Context
 	stopWorkers context.CancelFunc
 
 	enqueueMx sync.Mutex
 }
 
+// CrawlerOption configures optional Crawler behavior.
+type CrawlerOption func(*Crawler)
+
+// WithRecrawlInterval turns the Crawler into an incremental
+// re-crawler: URLs are normally dropped by Enqueue once they have
+// been crawled, but with this option they are re-enqueued once
+// CrawledAt is older than d. Combined with a ConditionalFetcher,
+// this allows running the same crawl database periodically to keep
+// an archive up to date.
+func WithRecrawlInterval(d time.Duration) CrawlerOption {
+	return func(c *Crawler) { c.recrawlInterval = d }
+}
+
 func normalizeURL(u *url.URL) *url.URL {
 	urlStr := purell.NormalizeURL(u,
 		purell.FlagsSafe|purell.FlagRemoveDotSegments|purell.FlagRemoveDuplicateSlashes|
@@ -182,7 +214,23 @@ func (c *Crawler) Enqueue(link Outlink, depth int) error {
 	var info URLInfo
 	ukey := []byte(fmt.Sprintf("url/%s", link.URL.String()))
 	if err := c.db.GetObj(ukey, &info); err == nil {
-		return nil
+		if c.recrawlInterval <= 0 || info.CrawledAt.IsZero() || time.Since(info.CrawledAt) < c.recrawlInterval {
+			return nil
+		}
+
+		// The URL was crawled more than recrawlInterval ago:
+		// re-enqueue it, preserving the stored record (and its
+		// ETag/LastModified validators) so the fetch can be
+		// conditional. Bump CrawledAt optimistically once it is
+		// queued, so that concurrent Enqueue calls for the same
+		// (popular) URL see it as freshly requeued and dedupe,
+		// the same way first-time discovery dedupes via the
+		// empty placeholder below.
+		if err := c.addNewURLToQueue(link.URL, depth); err != nil {
+			return err
+		}
+		info.CrawledAt = time.Now()
+		return c.db.PutObj(ukey, &info)
 	}
 
 	// Store the URL in the queue, and store an empty URLInfo to
@@ -191,6 +239,7 @@ func (c *Crawler) Enqueue(link Outlink, depth int) error {
 	if err := c.addNewURLToQueue(link.URL, depth); err != nil {
 		return err
 	}
+	metrics.URLsEnqueued.Inc()
 
 	return c.db.PutObj(ukey, &info)
 }
@@ -206,7 +255,11 @@ func (c *Crawler) addNewURLToQueue(uri *url.URL, depth int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultRPCTimeout)
 	defer cancel()
 	tag := []byte(uri.Host)
-	return c.queue.Add(ctx, tag, data)
+	if err := c.queue.Add(ctx, tag, data); err != nil {
+		return err
+	}
+	metrics.QueueDepth.Inc()
+	return nil
 }
 
 // Scan the queue for URLs until there are no more.
@@ -219,8 +272,17 @@ func (c *Crawler) worker(ctx context.Context) {
 			log.Printf("queue.Next() error: %v", err)
 			return
 		}
-
-		if err := job.Done(ctx, c.handleJob(ctx, job)); err != nil {
+		metrics.QueueDepth.Dec()
+
+		jobErr := c.handleJob(ctx, job)
+		if jobErr == ErrRetryRequest {
+			// The underlying queue will redeliver this job
+			// later without going through addNewURLToQueue,
+			// so restore the gauge to account for it still
+			// being outstanding.
+			metrics.QueueDepth.Inc()
+		}
+		if err := job.Done(ctx, jobErr); err != nil {
 			log.Printf("job.Done() error: %v", err)
 		}
 	}
@@ -247,12 +309,37 @@ func (c *Crawler) handleURL(ctx context.Context, item *queueItem) error {
 
 	// Fetch the URL and handle it. Make sure to Close the
 	// response body (even if it gets replaced in the
-	// Response object).
+	// Response object). If the fetcher supports conditional GETs
+	// and we have validators from a previous crawl, use them.
 	fmt.Printf("%s\n", item.URL)
-	httpResp, httpErr := c.fetcher.Fetch(item.URL)
+
+	host := item.URL
+	if u, uerr := url.Parse(item.URL); uerr == nil {
+		host = u.Host
+	}
+	metrics.HostInflight.WithLabelValues(host).Inc()
+	defer metrics.HostInflight.WithLabelValues(host).Dec()
+
+	start := time.Now()
+	var httpResp *http.Response
+	var httpErr error
+	if cf, ok := c.fetcher.(ConditionalFetcher); ok && (info.ETag != "" || info.LastModified != "") {
+		httpResp, httpErr = cf.FetchConditional(item.URL, info.ETag, info.LastModified)
+	} else {
+		httpResp, httpErr = c.fetcher.Fetch(item.URL)
+	}
+	metrics.FetchDuration.Observe(time.Since(start).Seconds())
 	if httpErr == nil {
 		defer httpResp.Body.Close() // nolint
 		info.StatusCode = httpResp.StatusCode
+		httpResp.Body = metrics.CountBytes(httpResp.Body)
+		metrics.URLsFetched.WithLabelValues(strconv.Itoa(httpResp.StatusCode)).Inc()
+		if et := httpResp.Header.Get("ETag"); et != "" {
+			info.ETag = et
+		}
+		if lm := httpResp.Header.Get("Last-Modified"); lm != "" {
+			info.LastModified = lm
+		}
 	}
 
 	// Invoke the handler (even if the fetcher errored
@@ -263,6 +350,7 @@ func (c *Crawler) handleURL(ctx context.Context, item *queueItem) error {
 	switch err {
 	case nil:
 	case ErrRetryRequest:
+		metrics.Retries.Inc()
 		return err
 	default:
 		// Unexpected fatal error in handler.
@@ -288,7 +376,7 @@ func MustParseURLs(urls []string) []*url.URL {
 }
 
 // NewCrawler creates a new Crawler object with the specified behavior.
-func NewCrawler(path string, seeds []*url.URL, scope Scope, f Fetcher, h Handler) (*Crawler, error) {
+func NewCrawler(path string, seeds []*url.URL, scope Scope, f Fetcher, h Handler, opts ...CrawlerOption) (*Crawler, error) {
 	// Open the crawl database.
 	db, err := newGobDB(path)
 	if err != nil {
@@ -316,6 +404,10 @@ func NewCrawler(path string, seeds []*url.URL, scope Scope, f Fetcher, h Handler
 		stopWorkers: cancel,
 	}
 
+	for _, opt := range opts {
+		opt(c)
+	}
+
 	return c, nil
 }
 
@@ -350,6 +442,20 @@ func (c *Crawler) Close() {
 	c.db.Close() // nolint
 }
 
+// PutObj stores obj under key in the crawler's persistent state
+// database, serialized with encoding/gob. It is exposed so that
+// Handler implementations can keep their own bookkeeping alongside
+// the crawl state (e.g. a payload-digest index).
+func (c *Crawler) PutObj(key []byte, obj interface{}) error {
+	return c.db.PutObj(key, obj)
+}
+
+// GetObj retrieves and decodes into obj the value stored under key
+// by a previous call to PutObj.
+func (c *Crawler) GetObj(key []byte, obj interface{}) error {
+	return c.db.GetObj(key, obj)
+}
+
 // FollowRedirects returns a Handler that follows HTTP redirects
 // and adds them to the queue for crawling. It will call the wrapped
 // handler on all requests regardless.
@@ -0,0 +1,71 @@
+package crawl
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"git.autistici.org/ale/crawl/metrics"
+)
+
+// alwaysInScope is a Scope that admits every URL, for use in tests
+// that don't care about scope filtering.
+type alwaysInScope struct{}
+
+func (alwaysInScope) Check(Outlink, int) bool { return true }
+
+func TestWorkerRestoresQueueDepthOnRetry(t *testing.T) {
+	var retried bool
+	handler := HandlerFunc(func(c *Crawler, u string, depth int, resp *http.Response, err error) error {
+		if !retried {
+			retried = true
+			return ErrRetryRequest
+		}
+		return nil
+	})
+	fetcher := FetcherFunc(func(string) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+
+	seeds := MustParseURLs([]string{"https://example.com/"})
+	c, err := NewCrawler(t.TempDir(), seeds, alwaysInScope{}, fetcher, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	before := testutil.ToFloat64(metrics.QueueDepth)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.worker(ctx)
+		close(done)
+	}()
+
+	if err := c.Enqueue(Outlink{URL: seeds[0], Tag: TagPrimary}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !retried && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !retried {
+		t.Fatal("handler was never invoked")
+	}
+
+	cancel()
+	<-done
+
+	// The retried job is still outstanding (the queue will redeliver
+	// it later), so the gauge should count it exactly once.
+	if after := testutil.ToFloat64(metrics.QueueDepth); after != before+1 {
+		t.Errorf("crawl_queue_depth went from %v to %v across a retried job, want %v", before, after, before+1)
+	}
+}
@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCountBytes(t *testing.T) {
+	before := testutil.ToFloat64(FetchBytes)
+
+	rc := CountBytes(ioutil.NopCloser(strings.NewReader("hello world")))
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("got %q", data)
+	}
+
+	if got := testutil.ToFloat64(FetchBytes) - before; got != float64(len(data)) {
+		t.Errorf("FetchBytes increased by %v, want %v", got, len(data))
+	}
+}
@@ -0,0 +1,407 @@
+package crawl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.autistici.org/ale/crawl/metrics"
+)
+
+// DefaultCrawlDelay is the minimum delay enforced between two
+// requests to the same host when robots.txt does not specify a
+// Crawl-delay directive.
+var DefaultCrawlDelay = 5 * time.Second
+
+// DefaultRobotsTTL is how long a cached robots.txt is considered
+// valid before it is re-fetched.
+var DefaultRobotsTTL = 24 * time.Hour
+
+// DefaultRobotsUserAgent is the User-agent token matched against
+// robots.txt rules when no other option is specified.
+var DefaultRobotsUserAgent = "crawl"
+
+// robotsRuleSet's fields are exported even though the type itself is
+// not: robotsRecord (which embeds it) is persisted with gobDB's
+// encoding/gob-based PutObj/GetObj, and gob silently drops unexported
+// struct fields on both encode and decode.
+type robotsRuleSet struct {
+	Allow      []string
+	Disallow   []string
+	CrawlDelay time.Duration
+}
+
+type robotsRecord struct {
+	FetchedAt time.Time
+	Rules     robotsRuleSet
+	Sitemaps  []string
+}
+
+// PoliteFetcher wraps a Fetcher and enforces robots.txt policies as
+// well as a minimum delay between successive requests to the same
+// host.
+type PoliteFetcher struct {
+	inner     Fetcher
+	userAgent string
+	delay     time.Duration
+	robotsTTL time.Duration
+	cache     *gobDB
+
+	mx     sync.Mutex
+	robots map[string]robotsRecord
+	last   map[string]time.Time
+}
+
+// PoliteFetcherOption configures a PoliteFetcher.
+type PoliteFetcherOption func(*PoliteFetcher)
+
+// WithRobotsCachePath makes the PoliteFetcher persist the robots.txt
+// cache in a LevelDB database at the given path, instead of keeping
+// it in memory only.
+func WithRobotsCachePath(path string) PoliteFetcherOption {
+	return func(p *PoliteFetcher) {
+		db, err := newGobDB(path)
+		if err == nil {
+			p.cache = db
+		}
+	}
+}
+
+// WithDefaultCrawlDelay sets the delay enforced between requests to
+// the same host when robots.txt does not specify a Crawl-delay.
+func WithDefaultCrawlDelay(d time.Duration) PoliteFetcherOption {
+	return func(p *PoliteFetcher) { p.delay = d }
+}
+
+// WithRobotsTTL sets how long a cached robots.txt is considered
+// fresh before being re-fetched.
+func WithRobotsTTL(d time.Duration) PoliteFetcherOption {
+	return func(p *PoliteFetcher) { p.robotsTTL = d }
+}
+
+// WithRobotsUserAgent sets the User-agent token used both to fetch
+// content and to select the applicable robots.txt rule group.
+func WithRobotsUserAgent(ua string) PoliteFetcherOption {
+	return func(p *PoliteFetcher) { p.userAgent = ua }
+}
+
+// NewPoliteFetcher creates a PoliteFetcher wrapping inner.
+func NewPoliteFetcher(inner Fetcher, opts ...PoliteFetcherOption) *PoliteFetcher {
+	p := &PoliteFetcher{
+		inner:     inner,
+		userAgent: DefaultRobotsUserAgent,
+		delay:     DefaultCrawlDelay,
+		robotsTTL: DefaultRobotsTTL,
+		robots:    make(map[string]robotsRecord),
+		last:      make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Fetch waits until it is allowed to hit the URL's host again, then
+// delegates to the wrapped Fetcher. It returns ErrDisallowedByRobots
+// if the URL is disallowed by the host's robots.txt.
+func (p *PoliteFetcher) Fetch(rawurl string) (*http.Response, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.Allowed(u) {
+		return nil, ErrDisallowedByRobots
+	}
+
+	p.wait(u)
+
+	return p.inner.Fetch(rawurl)
+}
+
+// FetchConditional behaves like Fetch, but performs a conditional GET
+// if the wrapped Fetcher implements ConditionalFetcher, passing
+// through unchanged otherwise.
+func (p *PoliteFetcher) FetchConditional(rawurl, etag, lastModified string) (*http.Response, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.Allowed(u) {
+		return nil, ErrDisallowedByRobots
+	}
+
+	p.wait(u)
+
+	if cf, ok := p.inner.(ConditionalFetcher); ok {
+		return cf.FetchConditional(rawurl, etag, lastModified)
+	}
+	return p.inner.Fetch(rawurl)
+}
+
+// ErrDisallowedByRobots is returned by PoliteFetcher.Fetch when the
+// requested URL is disallowed by the host's robots.txt.
+var ErrDisallowedByRobots = fmt.Errorf("disallowed by robots.txt")
+
+// Allowed returns true if u can be fetched according to the policy
+// directives published by its host's robots.txt.
+func (p *PoliteFetcher) Allowed(u *url.URL) bool {
+	rules := p.rulesForHost(u)
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	best := -1
+	allowed := true
+	for _, pat := range rules.Disallow {
+		if l := matchRobotsPattern(pat, path); l > best {
+			best, allowed = l, false
+		}
+	}
+	// On a tie, Allow wins over Disallow (RFC 9309 sec. 2.2.2), hence
+	// ">=" here but ">" in the Disallow loop above.
+	for _, pat := range rules.Allow {
+		if l := matchRobotsPattern(pat, path); l >= best {
+			best, allowed = l, true
+		}
+	}
+	return allowed
+}
+
+// crawlDelay returns the Crawl-delay directive published by u's
+// host robots.txt, or zero if none was set.
+func (p *PoliteFetcher) crawlDelay(u *url.URL) time.Duration {
+	return p.recordForHost(u).Rules.CrawlDelay
+}
+
+// Sitemaps returns the sitemap URLs advertised by u's host
+// robots.txt via "Sitemap:" directives, if any.
+func (p *PoliteFetcher) Sitemaps(u *url.URL) []string {
+	return p.recordForHost(u).Sitemaps
+}
+
+func (p *PoliteFetcher) rulesForHost(u *url.URL) robotsRuleSet {
+	return p.recordForHost(u).Rules
+}
+
+func (p *PoliteFetcher) recordForHost(u *url.URL) robotsRecord {
+	key := []byte(fmt.Sprintf("robots/%s", u.Host))
+
+	p.mx.Lock()
+	rec, ok := p.robots[u.Host]
+	p.mx.Unlock()
+
+	if !ok && p.cache != nil {
+		var cached robotsRecord
+		if err := p.cache.GetObj(key, &cached); err == nil {
+			rec, ok = cached, true
+		}
+	}
+
+	if ok && time.Since(rec.FetchedAt) < p.robotsTTL {
+		return rec
+	}
+
+	rules, sitemaps := p.fetchRobots(u)
+	rec = robotsRecord{
+		FetchedAt: time.Now(),
+		Rules:     rules,
+		Sitemaps:  sitemaps,
+	}
+
+	p.mx.Lock()
+	p.robots[u.Host] = rec
+	p.mx.Unlock()
+	if p.cache != nil {
+		p.cache.PutObj(key, &rec) // nolint
+	}
+
+	return rec
+}
+
+func (p *PoliteFetcher) fetchRobots(u *url.URL) (robotsRuleSet, []string) {
+	robotsURL := fmt.Sprintf("%s://%s/robots.txt", u.Scheme, u.Host)
+
+	resp, err := p.inner.Fetch(robotsURL)
+	if err != nil {
+		return robotsRuleSet{}, nil
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode >= 400 {
+		return robotsRuleSet{}, nil
+	}
+
+	return parseRobots(resp.Body, p.userAgent)
+}
+
+// wait blocks until it is safe to issue another request to u's host,
+// then reserves the next slot. The reservation is made while still
+// holding p.mx so that two goroutines racing to fetch the same host
+// cannot both read the same p.last value and both sleep for less
+// than delay.
+func (p *PoliteFetcher) wait(u *url.URL) {
+	delay := p.delay
+	if d := p.crawlDelay(u); d > 0 {
+		delay = d
+	}
+
+	host := u.Host
+	p.mx.Lock()
+	next := time.Now()
+	if last, ok := p.last[host]; ok {
+		if t := last.Add(delay); t.After(next) {
+			next = t
+		}
+	}
+	p.last[host] = next
+	p.mx.Unlock()
+
+	if d := time.Until(next); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// parseRobots parses a robots.txt document, returning the rule set
+// that applies to userAgent (falling back to the wildcard group "*"
+// if there is no exact match) along with any Sitemap: directives,
+// which apply regardless of user-agent group.
+func parseRobots(r io.Reader, userAgent string) (robotsRuleSet, []string) {
+	groups := map[string]*robotsRuleSet{}
+	var current []string
+	var inUAGroup bool
+	var sitemaps []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		field := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		switch field {
+		case "sitemap":
+			inUAGroup = false
+			sitemaps = append(sitemaps, value)
+		case "user-agent":
+			ua := strings.ToLower(value)
+			if _, ok := groups[ua]; !ok {
+				groups[ua] = &robotsRuleSet{}
+			}
+			// A run of consecutive User-agent lines shares one
+			// rule set, so only start a new group when the
+			// previous line wasn't itself a User-agent line.
+			if !inUAGroup {
+				current = current[:0]
+			}
+			current = append(current, ua)
+			inUAGroup = true
+		case "allow", "disallow", "crawl-delay":
+			inUAGroup = false
+			if len(current) == 0 {
+				continue
+			}
+			for _, ua := range current {
+				g := groups[ua]
+				switch field {
+				case "allow":
+					if value != "" {
+						g.Allow = append(g.Allow, value)
+					}
+				case "disallow":
+					if value != "" {
+						g.Disallow = append(g.Disallow, value)
+					}
+				case "crawl-delay":
+					if secs, err := strconv.ParseFloat(value, 64); err == nil {
+						g.CrawlDelay = time.Duration(secs * float64(time.Second))
+					}
+				}
+			}
+		}
+	}
+
+	ua := strings.ToLower(userAgent)
+	if g, ok := groups[ua]; ok {
+		return *g, sitemaps
+	}
+	if g, ok := groups["*"]; ok {
+		return *g, sitemaps
+	}
+	return robotsRuleSet{}, sitemaps
+}
+
+// matchRobotsPattern returns the length of pat if it matches path as
+// a robots.txt rule (supporting the "*" wildcard and "$" end anchor
+// as per the de-facto extended robots.txt standard), or -1 if it
+// does not match.
+func matchRobotsPattern(pat, path string) int {
+	anchored := strings.HasSuffix(pat, "$")
+	pat = strings.TrimSuffix(pat, "$")
+
+	segments := strings.Split(pat, "*")
+	pos := 0
+	for i, seg := range segments {
+		if seg == "" {
+			continue
+		}
+		idx := strings.Index(path[pos:], seg)
+		if anchored && i == len(segments)-1 {
+			// The last segment of an anchored pattern has to end
+			// at the end of path, so use its last occurrence.
+			idx = strings.LastIndex(path[pos:], seg)
+		}
+		if idx < 0 {
+			return -1
+		}
+		if i == 0 && idx != 0 {
+			return -1
+		}
+		pos += idx + len(seg)
+	}
+	if anchored && pos != len(path) && !strings.HasSuffix(pat, "*") {
+		return -1
+	}
+	return len(pat)
+}
+
+type robotsScope struct {
+	pf *PoliteFetcher
+}
+
+// NewRobotsScope returns a Scope that drops URLs disallowed by the
+// robots.txt of their host, using pf's cache so that the policy
+// decided here is consistent with what Fetch will later enforce.
+func NewRobotsScope(pf *PoliteFetcher) Scope {
+	return &robotsScope{pf: pf}
+}
+
+func (s *robotsScope) Check(link Outlink, depth int) bool {
+	if s.pf.Allowed(link.URL) {
+		return true
+	}
+	metrics.RobotsBlocked.Inc()
+	return false
+}
@@ -0,0 +1,100 @@
+package crawl
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchRobotsPattern(t *testing.T) {
+	cases := []struct {
+		pat, path string
+		want      int
+	}{
+		{"/", "/anything", 1},
+		{"/foo", "/foo/bar", 4},
+		{"/foo", "/bar", -1},
+		{"/foo*bar", "/foo/baz/bar", 8},
+		{"/foo*bar", "/foobar", 8},
+		{"/foo*bar", "/foo", -1},
+		{"/foo$", "/foo", 4},
+		{"/foo$", "/foobar", -1},
+		{"", "/anything", 0},
+	}
+	for _, c := range cases {
+		if got := matchRobotsPattern(c.pat, c.path); got != c.want {
+			t.Errorf("matchRobotsPattern(%q, %q) = %d, want %d", c.pat, c.path, got, c.want)
+		}
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	doc := `
+User-agent: crawl
+Disallow: /private
+Allow: /private/public
+Crawl-delay: 2.5
+
+User-agent: *
+Disallow: /
+
+Sitemap: https://example.com/sitemap.xml
+`
+	rules, sitemaps := parseRobots(strings.NewReader(doc), "crawl")
+	if len(rules.Disallow) != 1 || rules.Disallow[0] != "/private" {
+		t.Errorf("unexpected Disallow: %v", rules.Disallow)
+	}
+	if len(rules.Allow) != 1 || rules.Allow[0] != "/private/public" {
+		t.Errorf("unexpected Allow: %v", rules.Allow)
+	}
+	if rules.CrawlDelay != 2500*time.Millisecond {
+		t.Errorf("unexpected CrawlDelay: %v", rules.CrawlDelay)
+	}
+	if len(sitemaps) != 1 || sitemaps[0] != "https://example.com/sitemap.xml" {
+		t.Errorf("unexpected Sitemaps: %v", sitemaps)
+	}
+}
+
+func TestAllowedTieBreaksToAllow(t *testing.T) {
+	p := NewPoliteFetcher(nil)
+	p.robots["example.com"] = robotsRecord{
+		FetchedAt: time.Now(),
+		Rules: robotsRuleSet{
+			Allow:    []string{"/foo"},
+			Disallow: []string{"/foo"},
+		},
+	}
+	u, _ := url.Parse("https://example.com/foo")
+	if !p.Allowed(u) {
+		t.Error("expected equally-specific Allow to win over Disallow")
+	}
+}
+
+func TestParseRobotsSharedMultiUserAgentGroup(t *testing.T) {
+	doc := `
+User-agent: crawlerA
+User-agent: crawlerB
+Disallow: /private
+
+User-agent: *
+Disallow: /
+`
+	for _, ua := range []string{"crawlerA", "crawlerB"} {
+		rules, _ := parseRobots(strings.NewReader(doc), ua)
+		if len(rules.Disallow) != 1 || rules.Disallow[0] != "/private" {
+			t.Errorf("%s: unexpected Disallow: %v", ua, rules.Disallow)
+		}
+	}
+}
+
+func TestParseRobotsFallsBackToWildcard(t *testing.T) {
+	doc := `
+User-agent: *
+Disallow: /admin
+`
+	rules, _ := parseRobots(strings.NewReader(doc), "crawl")
+	if len(rules.Disallow) != 1 || rules.Disallow[0] != "/admin" {
+		t.Errorf("expected fallback to wildcard group, got %v", rules.Disallow)
+	}
+}
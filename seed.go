@@ -0,0 +1,87 @@
+package crawl
+
+import (
+	"net/url"
+
+	"git.autistici.org/ale/crawl/sitemap"
+)
+
+// sitemapPaths are the well-known locations probed on every host
+// registered with a SitemapSeeder.
+var sitemapPaths = []string{"/sitemap.xml", "/sitemap_index.xml"}
+
+// SitemapSeeder discovers additional seed URLs for a crawl by
+// fetching and parsing the sitemap.xml (or sitemap_index.xml) of one
+// or more hosts. It can be composed with an explicit seed list so
+// that large sites can be archived completely, instead of relying
+// purely on link discovery, which frequently misses orphan pages.
+type SitemapSeeder struct {
+	fetcher Fetcher
+	hosts   map[string]string // host -> scheme
+
+	// extraURLs holds fully-qualified sitemap URLs discovered
+	// outside of the well-known paths, e.g. via a robots.txt
+	// Sitemap: directive.
+	extraURLs []string
+}
+
+// NewSitemapSeeder creates a SitemapSeeder that will probe the host
+// of each of the given seed URLs, fetching sitemaps with f.
+func NewSitemapSeeder(f Fetcher, seeds []*url.URL) *SitemapSeeder {
+	s := &SitemapSeeder{
+		fetcher: f,
+		hosts:   make(map[string]string),
+	}
+	for _, u := range seeds {
+		s.AddHost(u.Scheme, u.Host)
+	}
+	return s
+}
+
+// AddHost registers an additional host to probe for a sitemap, e.g.
+// one discovered via a robots.txt Sitemap: directive.
+func (s *SitemapSeeder) AddHost(scheme, host string) {
+	if _, ok := s.hosts[host]; !ok {
+		s.hosts[host] = scheme
+	}
+}
+
+// AddSitemapURL registers an additional, fully-qualified sitemap
+// URL to fetch, e.g. one advertised by a robots.txt Sitemap:
+// directive.
+func (s *SitemapSeeder) AddSitemapURL(sitemapURL string) {
+	s.extraURLs = append(s.extraURLs, sitemapURL)
+}
+
+// Seeds returns the outlinks discovered in the sitemap(s) of every
+// registered host and extra sitemap URL, tagged TagPrimary so they
+// are enqueued at depth 0 like any other seed.
+func (s *SitemapSeeder) Seeds() []Outlink {
+	var outlinks []Outlink
+
+	fetch := func(sitemapURL string) {
+		locs, err := sitemap.Fetch(s.fetcher, sitemapURL)
+		if err != nil {
+			return
+		}
+		for _, loc := range locs {
+			if u, err := url.Parse(loc); err == nil {
+				outlinks = append(outlinks, Outlink{URL: u, Tag: TagPrimary})
+			}
+		}
+	}
+
+	for host, scheme := range s.hosts {
+		if scheme == "" {
+			scheme = "https"
+		}
+		for _, path := range sitemapPaths {
+			fetch(scheme + "://" + host + path)
+		}
+	}
+	for _, u := range s.extraURLs {
+		fetch(u)
+	}
+
+	return outlinks
+}
@@ -0,0 +1,114 @@
+// Package sitemap fetches and parses sitemap.xml files as described
+// by the sitemaps.org protocol, including gzip-compressed sitemaps
+// and nested sitemap indices.
+package sitemap
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// A Fetcher retrieves contents from remote URLs. It is satisfied by
+// crawl.Fetcher, without introducing a dependency on that package.
+type Fetcher interface {
+	Fetch(string) (*http.Response, error)
+}
+
+type urlEntry struct {
+	Loc string `xml:"loc"`
+}
+
+type urlSet struct {
+	XMLName xml.Name   `xml:"urlset"`
+	URLs    []urlEntry `xml:"url"`
+}
+
+type sitemapEntry struct {
+	Loc string `xml:"loc"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name       `xml:"sitemapindex"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+// maxSitemapDepth bounds how deeply Fetch will recurse into nested
+// sitemap indices, as a guard against cyclic or adversarial
+// sitemap_index.xml documents on third-party sites.
+const maxSitemapDepth = 5
+
+// Fetch retrieves and parses the sitemap (or sitemap index) at u,
+// recursing into any nested sitemaps and returning the flattened
+// list of <loc> URLs found. Gzip-compressed sitemaps (either served
+// with a ".gz" extension or a gzip Content-Type) are transparently
+// decompressed.
+func Fetch(f Fetcher, u string) ([]string, error) {
+	return fetch(f, u, make(map[string]bool), 0)
+}
+
+func fetch(f Fetcher, u string, seen map[string]bool, depth int) ([]string, error) {
+	if depth >= maxSitemapDepth || seen[u] {
+		return nil, nil
+	}
+	seen[u] = true
+
+	resp, err := f.Fetch(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("sitemap: %s: %s", u, resp.Status)
+	}
+
+	r, err := maybeGunzip(u, resp)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var idx sitemapIndex
+	if err := xml.Unmarshal(data, &idx); err == nil && len(idx.Sitemaps) > 0 {
+		var urls []string
+		for _, s := range idx.Sitemaps {
+			if s.Loc == "" {
+				continue
+			}
+			children, err := fetch(f, s.Loc, seen, depth+1)
+			if err != nil {
+				continue
+			}
+			urls = append(urls, children...)
+		}
+		return urls, nil
+	}
+
+	var set urlSet
+	if err := xml.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(set.URLs))
+	for _, entry := range set.URLs {
+		if entry.Loc != "" {
+			urls = append(urls, entry.Loc)
+		}
+	}
+	return urls, nil
+}
+
+func maybeGunzip(u string, resp *http.Response) (io.Reader, error) {
+	ctype := resp.Header.Get("Content-Type")
+	if strings.HasSuffix(u, ".gz") || ctype == "application/x-gzip" || ctype == "application/gzip" {
+		return gzip.NewReader(resp.Body)
+	}
+	return resp.Body, nil
+}
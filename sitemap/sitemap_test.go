@@ -0,0 +1,121 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFetcher serves canned bodies for a fixed set of URLs, for use
+// as a Fetcher in tests.
+type fakeFetcher struct {
+	bodies map[string]string
+	gzip   map[string]bool
+}
+
+func (f *fakeFetcher) Fetch(u string) (*http.Response, error) {
+	body, ok := f.bodies[u]
+	if !ok {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}
+	data := []byte(body)
+	header := http.Header{}
+	if f.gzip[u] {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write(data) // nolint
+		gw.Close()     // nolint
+		data = buf.Bytes()
+		header.Set("Content-Type", "application/gzip")
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       ioutil.NopCloser(bytes.NewReader(data)),
+	}, nil
+}
+
+func TestFetchURLSet(t *testing.T) {
+	f := &fakeFetcher{bodies: map[string]string{
+		"https://example.com/sitemap.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/a</loc></url>
+  <url><loc>https://example.com/b</loc></url>
+</urlset>`,
+	}}
+	urls, err := Fetch(f, "https://example.com/sitemap.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://example.com/a", "https://example.com/b"}
+	if len(urls) != len(want) || urls[0] != want[0] || urls[1] != want[1] {
+		t.Errorf("got %v, want %v", urls, want)
+	}
+}
+
+func TestFetchSitemapIndex(t *testing.T) {
+	f := &fakeFetcher{bodies: map[string]string{
+		"https://example.com/sitemap_index.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://example.com/sitemap1.xml</loc></sitemap>
+</sitemapindex>`,
+		"https://example.com/sitemap1.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/c</loc></url>
+</urlset>`,
+	}}
+	urls, err := Fetch(f, "https://example.com/sitemap_index.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0] != "https://example.com/c" {
+		t.Errorf("got %v, want [https://example.com/c]", urls)
+	}
+}
+
+func TestFetchGzipped(t *testing.T) {
+	f := &fakeFetcher{
+		bodies: map[string]string{
+			"https://example.com/sitemap.xml.gz": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/d</loc></url>
+</urlset>`,
+		},
+		gzip: map[string]bool{"https://example.com/sitemap.xml.gz": true},
+	}
+	urls, err := Fetch(f, "https://example.com/sitemap.xml.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0] != "https://example.com/d" {
+		t.Errorf("got %v, want [https://example.com/d]", urls)
+	}
+}
+
+func TestFetchCyclicIndexDoesNotRecurseForever(t *testing.T) {
+	f := &fakeFetcher{bodies: map[string]string{
+		"https://example.com/a.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://example.com/b.xml</loc></sitemap>
+</sitemapindex>`,
+		"https://example.com/b.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://example.com/a.xml</loc></sitemap>
+</sitemapindex>`,
+	}}
+
+	done := make(chan struct{})
+	go func() {
+		Fetch(f, "https://example.com/a.xml") // nolint
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Fetch did not terminate on a cyclic sitemap index")
+	}
+}